@@ -0,0 +1,120 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// reCommit matches a commit hash. References are not accepted so that user
+// input is never interpreted as a git option or revision range.
+var reCommit = regexp.MustCompile("^[0-9a-fA-F]{4,40}$")
+
+// commit is a commit as returned by the API.
+type commit struct {
+	SHA     string `json:"sha"`
+	Author  string `json:"author"`
+	Date    string `json:"date"`
+	Subject string `json:"subject"`
+}
+
+// maxCommits is the maximum number of commits returned by the changelog API.
+const maxCommits = 1000
+
+// changelog is the response of the changelog API.
+type changelog struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Commits []commit `json:"commits"`
+	// Truncated is set when the range has more than maxCommits commits; only
+	// the newest ones are returned.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// serveAPI handles requests under /api/.
+//
+// The only supported route is /api/v1/repos/<name>/changelog. Requests must
+// have an "Authorization: Bearer <secret>" header. The range defaults to the
+// previous and the current deploys and at most maxCommits commits are listed.
+func (s *server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	w, done := compress(w, r)
+	defer done()
+	p := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/repos/"), "/")
+	if !strings.HasPrefix(r.URL.Path, "/api/v1/repos/") || len(p) != 2 || p[0] != s.Name || p[1] != "changelog" {
+		log.Printf("- Unexpected path %s", r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		log.Printf("- invalid method %s", r.Method)
+		return
+	}
+	// The changelog exposes the repository history, so it requires the webhook
+	// secret as a bearer token. The API is disabled when there is no secret.
+	if a := r.Header.Get("Authorization"); !strings.HasPrefix(a, "Bearer ") || !s.validSecret(a[len("Bearer "):]) {
+		http.Error(w, "Invalid secret", http.StatusUnauthorized)
+		log.Printf("- invalid secret")
+		return
+	}
+	s.dmu.Lock()
+	c := changelog{From: s.previous, To: s.current}
+	s.dmu.Unlock()
+	if v := r.FormValue("from"); v != "" {
+		c.From = v
+	}
+	if v := r.FormValue("to"); v != "" {
+		c.To = v
+	}
+	if c.From == "" {
+		http.Error(w, "No previous deploy", http.StatusNotFound)
+		log.Printf("- no previous deploy")
+		return
+	}
+	if !reCommit.MatchString(c.From) || !reCommit.MatchString(c.To) {
+		http.Error(w, "Invalid from or to", http.StatusBadRequest)
+		log.Printf("- invalid range %q..%q", c.From, c.To)
+		return
+	}
+	// Check that both ends exist cheaply, so conditional requests are answered
+	// without listing the commits. --verify accepts a single revision.
+	for _, v := range []string{c.From, c.To} {
+		if _, err := runQuiet("git", "rev-parse", "--verify", "-q", v+"^{commit}"); err != nil {
+			http.Error(w, "Unknown revision", http.StatusNotFound)
+			return
+		}
+	}
+	// The log between two commits never changes, but the defaults move with
+	// each deploy so only cache shortly. Only existing ranges are cacheable.
+	etag := `"` + c.From + ".." + c.To + `"`
+	w.Header().Set("Cache-Control", "max-age=10")
+	w.Header().Set("ETag", etag)
+	if strings.TrimPrefix(r.Header.Get("If-None-Match"), "W/") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	out, err := runQuiet("git", "log", "-n", strconv.Itoa(maxCommits+1), "--format=%H%x00%an%x00%aI%x00%s", c.From+".."+c.To)
+	if err != nil {
+		http.Error(w, "Unknown revision", http.StatusNotFound)
+		return
+	}
+	c.Commits = []commit{}
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if f := strings.SplitN(l, "\x00", 4); len(f) == 4 {
+			c.Commits = append(c.Commits, commit{SHA: f[0], Author: f[1], Date: f[2], Subject: f[3]})
+		}
+	}
+	if len(c.Commits) > maxCommits {
+		c.Commits = c.Commits[:maxCommits]
+		c.Truncated = true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&c)
+}
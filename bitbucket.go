@@ -0,0 +1,70 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// bitbucketRefsChanged is the subset of a Bitbucket Server repo:refs_changed
+// event used by pullhook.
+//
+// See https://confluence.atlassian.com/bitbucketserver/event-payload-938025882.html
+type bitbucketRefsChanged struct {
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	} `json:"repository"`
+	Changes []struct {
+		RefID  string `json:"refId"`
+		ToHash string `json:"toHash"`
+		Type   string `json:"type"`
+	} `json:"changes"`
+}
+
+// serveBitbucketServer handles a Bitbucket Server or Data Center delivery.
+func (s *server) serveBitbucketServer(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rejectBody(w, r, err)
+		return
+	}
+	if !validSignature(r.Header.Get("X-Hub-Signature"), b, s.WebHookSecret) {
+		reject(w, r, http.StatusUnauthorized, "secret")
+		return
+	}
+	switch t := r.Header.Get("X-Event-Key"); t {
+	case "diagnostics:ping":
+	case "repo:refs_changed":
+		var e bitbucketRefsChanged
+		if err = json.Unmarshal(b, &e); err != nil {
+			reject(w, r, http.StatusBadRequest, "payload")
+			return
+		}
+		repo := e.Repository.Project.Key + "/" + e.Repository.Slug
+		// All the refs are fetched by a single pull.
+		pushed := false
+		for _, c := range e.Changes {
+			if c.Type == "DELETE" {
+				log.Printf("- Push %s %s <deleted>", repo, c.RefID)
+				continue
+			}
+			log.Printf("- Push %s %s %s", repo, c.RefID, c.ToHash)
+			if !pushed {
+				pushed = true
+				s.enqueue(&deploy{Repo: repo, Ref: c.RefID})
+			}
+		}
+	default:
+		log.Printf("- ignoring Bitbucket event %s", t)
+	}
+	io.WriteString(w, "{}")
+}
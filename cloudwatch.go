@@ -0,0 +1,221 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imds is the EC2 instance metadata service.
+const imds = "http://169.254.169.254/latest/"
+
+// cloudwatch publishes deploy metrics to AWS CloudWatch.
+//
+// Credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables, or from the instance role when
+// running on EC2.
+type cloudwatch struct {
+	Namespace  string
+	Region     string
+	Dimensions [][2]string
+
+	mu    sync.Mutex
+	creds awsCreds
+}
+
+// awsCreds are AWS credentials.
+type awsCreds struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// newCloudwatch returns a CloudWatch publisher. dims is a comma separated list
+// of Name=Value dimensions. If region is empty, it is determined from the
+// environment or the instance metadata.
+func newCloudwatch(namespace, region, dims string) (*cloudwatch, error) {
+	c := &cloudwatch{Namespace: namespace, Region: region}
+	for _, d := range strings.Split(dims, ",") {
+		if d == "" {
+			continue
+		}
+		kv := strings.SplitN(d, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid CloudWatch dimension %q", d)
+		}
+		c.Dimensions = append(c.Dimensions, [2]string{kv[0], kv[1]})
+	}
+	if c.Region == "" {
+		c.Region = os.Getenv("AWS_REGION")
+	}
+	if c.Region == "" {
+		r, err := imdsGet("meta-data/placement/region")
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine AWS region: %v", err)
+		}
+		c.Region = r
+	}
+	return c, nil
+}
+
+func (c *cloudwatch) notify(d *deploy) error {
+	failures := "0"
+	if d.Err != nil {
+		failures = "1"
+	}
+	v := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {c.Namespace},
+	}
+	for i, m := range [][3]string{
+		{"Deploys", "1", "Count"},
+		{"Failures", failures, "Count"},
+		{"Duration", strconv.FormatInt(int64(d.Duration/time.Millisecond), 10), "Milliseconds"},
+	} {
+		p := fmt.Sprintf("MetricData.member.%d.", i+1)
+		v.Set(p+"MetricName", m[0])
+		v.Set(p+"Value", m[1])
+		v.Set(p+"Unit", m[2])
+		v.Set(p+"Timestamp", d.Start.UTC().Format(time.RFC3339))
+		for j, dim := range c.Dimensions {
+			q := fmt.Sprintf("%sDimensions.member.%d.", p, j+1)
+			v.Set(q+"Name", dim[0])
+			v.Set(q+"Value", dim[1])
+		}
+	}
+	creds, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	body := v.Encode()
+	host := "monitoring." + c.Region + ".amazonaws.com"
+	h := signV4(creds, c.Region, "monitoring", host, "application/x-www-form-urlencoded; charset=utf-8", body, time.Now().UTC())
+	return post("https://"+host+"/", h.Get("Content-Type"), h, strings.NewReader(body))
+}
+
+// signV4 returns the headers to send with a POST request to host with body,
+// using AWS signature version 4. contentType is optional.
+//
+// See https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func signV4(creds awsCreds, region, service, host, contentType, body string, now time.Time) http.Header {
+	date := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	h := http.Header{}
+	headers := ""
+	signed := ""
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+		headers += "content-type:" + contentType + "\n"
+		signed += "content-type;"
+	}
+	h.Set("X-Amz-Date", date)
+	headers += "host:" + host + "\nx-amz-date:" + date + "\n"
+	signed += "host;x-amz-date"
+	if creds.Token != "" {
+		h.Set("X-Amz-Security-Token", creds.Token)
+		headers += "x-amz-security-token:" + creds.Token + "\n"
+		signed += ";x-amz-security-token"
+	}
+	canonical := "POST\n/\n\n" + headers + "\n" + signed + "\n" + sha256Hex(body)
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + sha256Hex(canonical)
+	k := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	k = hmacSHA256(k, "aws4_request")
+	h.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+", SignedHeaders="+signed+", Signature="+hex.EncodeToString(hmacSHA256(k, toSign)))
+	return h
+}
+
+// credentials returns the AWS credentials to use, refreshing the instance
+// role credentials when they are about to expire.
+func (c *cloudwatch) credentials() (awsCreds, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCreds{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Until(c.creds.Expiration) > 5*time.Minute {
+		return c.creds, nil
+	}
+	role, err := imdsGet("meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCreds{}, err
+	}
+	if role = strings.SplitN(role, "\n", 2)[0]; role == "" {
+		return awsCreds{}, errors.New("no instance role")
+	}
+	b, err := imdsGet("meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return awsCreds{}, err
+	}
+	var creds awsCreds
+	if err := json.Unmarshal([]byte(b), &creds); err != nil {
+		return awsCreds{}, err
+	}
+	c.creds = creds
+	return creds, nil
+}
+
+// imdsGet fetches a path from the instance metadata service, using IMDSv2.
+func imdsGet(path string) (string, error) {
+	c := &http.Client{Timeout: 2 * time.Second}
+	req, err := http.NewRequest("PUT", imds+"api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := imdsDo(c, req)
+	if err != nil {
+		return "", err
+	}
+	if req, err = http.NewRequest("GET", imds+path, nil); err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return imdsDo(c, req)
+}
+
+func imdsDo(c *http.Client, req *http.Request) (string, error) {
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSignV4 uses vectors from the AWS Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	creds := awsCreds{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now, err := time.Parse("20060102T150405Z", "20150830T123600Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			"post-vanilla",
+			"",
+			"",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			"post-x-www-form-urlencoded",
+			"application/x-www-form-urlencoded",
+			"Param1=value1",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+	for _, line := range data {
+		h := signV4(creds, "us-east-1", "service", "example.amazonaws.com", line.contentType, line.body, now)
+		if got := h.Get("Authorization"); got != line.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", line.name, got, line.want)
+		}
+		if got := h.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: X-Amz-Date = %q", line.name, got)
+		}
+	}
+}
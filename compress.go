@@ -0,0 +1,92 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipWriter compresses the response body.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz    *gzip.Writer
+	wrote bool
+}
+
+// compress returns a ResponseWriter that compresses the response if the
+// client accepts it. The returned function must be called once the response
+// is written.
+func compress(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		return w, func() {}
+	}
+	g := &gzipWriter{ResponseWriter: w}
+	return g, func() {
+		if g.gz != nil {
+			g.gz.Close()
+		}
+	}
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if !g.wrote {
+		g.wrote = true
+		h := g.Header()
+		// The compressed body differs from the identity one.
+		if e := h.Get("ETag"); e != "" && !strings.HasPrefix(e, "W/") {
+			h.Set("ETag", "W/"+e)
+		}
+		if code != http.StatusNotModified && code != http.StatusNoContent {
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", "gzip")
+			g.gz = gzip.NewWriter(g.ResponseWriter)
+		}
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+	if !g.wrote {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.gz.Write(b)
+}
+
+// acceptsGzip returns true if the Accept-Encoding header value allows gzip.
+//
+// An explicit gzip entry takes precedence over "*".
+func acceptsGzip(v string) bool {
+	gzipQ, starQ := -1., -1.
+	for _, e := range strings.Split(v, ",") {
+		p := strings.Split(e, ";")
+		q := 1.
+		for _, param := range p[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") || strings.HasPrefix(param, "Q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(p[0])) {
+		case "gzip":
+			gzipQ = q
+		case "*":
+			starQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return starQ > 0
+}
@@ -0,0 +1,31 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestAcceptsGzip(t *testing.T) {
+	data := []struct {
+		in   string
+		want bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.000", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"*;q=0, gzip", true},
+		{"gzip;q=0, *", false},
+		{"br, deflate", false},
+	}
+	for _, line := range data {
+		if got := acceptsGzip(line.in); got != line.want {
+			t.Errorf("acceptsGzip(%q) = %t, want %t", line.in, got, line.want)
+		}
+	}
+}
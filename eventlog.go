@@ -0,0 +1,13 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+// Event IDs recorded in the system event log by logEvent.
+const (
+	eventStart        = 1
+	eventDeployed     = 2
+	eventDeployFailed = 3
+	eventRejected     = 4
+)
@@ -0,0 +1,15 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+func openEventLog() error {
+	return nil
+}
+
+func logEvent(id uint32, msg string) {
+}
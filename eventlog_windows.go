@@ -0,0 +1,51 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"log"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+var elog *eventlog.Log
+
+// openEventLog opens the Windows Event Log when running as a service, where
+// the console output is not visible.
+func openEventLog() error {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil || interactive {
+		return err
+	}
+	const src = "pullhook"
+	// Registering the source requires administrative rights and fails if it is
+	// already registered, so the error is ignored and Open reports problems.
+	_ = eventlog.InstallAsEventCreate(src, eventlog.Error|eventlog.Warning|eventlog.Info)
+	elog, err = eventlog.Open(src)
+	return err
+}
+
+// logEvent records a significant event in the Windows Event Log, if opened.
+func logEvent(id uint32, msg string) {
+	if elog == nil {
+		return
+	}
+	var err error
+	switch id {
+	case eventDeployFailed:
+		err = elog.Error(id, msg)
+	case eventRejected:
+		err = elog.Warning(id, msg)
+	default:
+		err = elog.Info(id, msg)
+	}
+	if err != nil {
+		log.Printf("- failed to write event log: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// gitlabPush is the subset of a GitLab push event used by pullhook. Project
+// hooks and system hooks use the same format.
+//
+// See https://docs.gitlab.com/ee/user/project/integrations/webhooks.html and
+// https://docs.gitlab.com/ee/system_hooks/system_hooks.html
+type gitlabPush struct {
+	ObjectKind string `json:"object_kind"`
+	EventName  string `json:"event_name"`
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+		GitSSHURL         string `json:"git_ssh_url"`
+	} `json:"project"`
+}
+
+// serveGitLab handles a GitLab project or system hook delivery.
+//
+// System hooks are sent for every project on the instance, so they are only
+// acted upon when the project is the origin of the checkout.
+func (s *server) serveGitLab(w http.ResponseWriter, r *http.Request) {
+	if !s.validSecret(r.Header.Get("X-Gitlab-Token")) {
+		reject(w, r, http.StatusUnauthorized, "secret")
+		return
+	}
+	t := r.Header.Get("X-Gitlab-Event")
+	// The commits included in the payload are skipped.
+	var e gitlabPush
+	err := decodeObject(r.Body, map[string]interface{}{
+		"object_kind": &e.ObjectKind,
+		"event_name":  &e.EventName,
+		"ref":         &e.Ref,
+		"after":       &e.After,
+		"project":     &e.Project,
+	})
+	if err != nil {
+		rejectBody(w, r, err)
+		return
+	}
+	kind := e.ObjectKind
+	if t == "System Hook" {
+		kind = e.EventName
+	}
+	switch {
+	case kind != "push" && kind != "tag_push":
+		log.Printf("- ignoring GitLab %s %s", t, kind)
+	case t == "System Hook" && !sameRemote(s.Remote, e.Project.GitHTTPURL) && !sameRemote(s.Remote, e.Project.GitSSHURL):
+		log.Printf("- ignoring GitLab push to %s", e.Project.PathWithNamespace)
+	case strings.Trim(e.After, "0") == "":
+		log.Printf("- Push %s %s <deleted>", e.Project.PathWithNamespace, e.Ref)
+	default:
+		log.Printf("- Push %s %s %s", e.Project.PathWithNamespace, e.Ref, e.After)
+		s.enqueue(&deploy{Repo: e.Project.PathWithNamespace, Ref: e.Ref})
+	}
+	io.WriteString(w, "{}")
+}
+
+// sameRemote returns true if the two git remote URLs point to the same
+// repository.
+func sameRemote(a, b string) bool {
+	n := func(u string) string {
+		return strings.TrimSuffix(strings.TrimRight(u, "/"), ".git")
+	}
+	return a != "" && n(a) == n(b)
+}
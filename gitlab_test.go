@@ -0,0 +1,81 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSameRemote(t *testing.T) {
+	data := []struct {
+		a, b string
+		want bool
+	}{
+		{"https://gitlab.com/a/b.git", "https://gitlab.com/a/b.git", true},
+		{"https://gitlab.com/a/b", "https://gitlab.com/a/b.git", true},
+		{"https://gitlab.com/a/b.git", "https://gitlab.com/a/b", true},
+		{"https://gitlab.com/a/b/", "https://gitlab.com/a/b.git", true},
+		{"git@gitlab.com:a/b.git", "git@gitlab.com:a/b", true},
+		{"git@gitlab.com:a/b.git", "https://gitlab.com/a/b.git", false},
+		{"https://gitlab.com/a/b.git", "https://gitlab.com/a/c.git", false},
+		{"", "", false},
+		{"", "https://gitlab.com/a/b.git", false},
+	}
+	for _, line := range data {
+		if got := sameRemote(line.a, line.b); got != line.want {
+			t.Errorf("sameRemote(%q, %q) = %t, want %t", line.a, line.b, got, line.want)
+		}
+	}
+}
+
+func TestServeGitLab(t *testing.T) {
+	project := func(path string) string {
+		return `"project":{"path_with_namespace":"` + path + `","git_http_url":"https://gitlab.com/` + path + `.git","git_ssh_url":"git@gitlab.com:` + path + `.git"}`
+	}
+	data := []struct {
+		name   string
+		event  string
+		token  string
+		remote string
+		body   string
+		code   int
+		queued bool
+	}{
+		{"project push", "Push Hook", "s3cret", "", `{"object_kind":"push","ref":"refs/heads/master","after":"1",` + project("a/b") + `}`, http.StatusOK, true},
+		{"tag push", "Tag Push Hook", "s3cret", "", `{"object_kind":"tag_push","ref":"refs/tags/v1","after":"1",` + project("a/b") + `}`, http.StatusOK, true},
+		{"other kind", "Merge Request Hook", "s3cret", "", `{"object_kind":"merge_request",` + project("a/b") + `}`, http.StatusOK, false},
+		{"deleted", "Push Hook", "s3cret", "", `{"object_kind":"push","ref":"refs/heads/x","after":"0000000000000000000000000000000000000000",` + project("a/b") + `}`, http.StatusOK, false},
+		{"invalid token", "Push Hook", "other", "", `{"object_kind":"push","ref":"refs/heads/master","after":"1",` + project("a/b") + `}`, http.StatusUnauthorized, false},
+		{"system origin http", "System Hook", "s3cret", "https://gitlab.com/a/b", `{"event_name":"push","ref":"refs/heads/master","after":"1",` + project("a/b") + `}`, http.StatusOK, true},
+		{"system origin ssh", "System Hook", "s3cret", "git@gitlab.com:a/b.git", `{"event_name":"push","ref":"refs/heads/master","after":"1",` + project("a/b") + `}`, http.StatusOK, true},
+		{"system other project", "System Hook", "s3cret", "https://gitlab.com/a/c.git", `{"event_name":"push","ref":"refs/heads/master","after":"1",` + project("a/b") + `}`, http.StatusOK, false},
+		{"system no origin", "System Hook", "s3cret", "", `{"event_name":"push","ref":"refs/heads/master","after":"1",` + project("a/b") + `}`, http.StatusOK, false},
+		{"system other event", "System Hook", "s3cret", "https://gitlab.com/a/b.git", `{"event_name":"project_create",` + project("a/b") + `}`, http.StatusOK, false},
+	}
+	for _, line := range data {
+		s := &server{WebHookSecret: "s3cret", Remote: line.remote, done: make(chan struct{}), pending: map[string]*deploy{}}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(line.body))
+		r.Header.Set("X-Gitlab-Event", line.event)
+		r.Header.Set("X-Gitlab-Token", line.token)
+		w := httptest.NewRecorder()
+		// Hold the queue so the deploy stays pending and is dropped instead of
+		// pulling.
+		s.mu.Lock()
+		s.ServeHTTP(w, r)
+		d := s.pending["a/b"]
+		close(s.done)
+		s.mu.Unlock()
+		s.wg.Wait()
+		if w.Code != line.code {
+			t.Errorf("%s: code = %d, want %d", line.name, w.Code, line.code)
+		}
+		if (d != nil) != line.queued {
+			t.Errorf("%s: queued = %t, want %t", line.name, d != nil, line.queued)
+		}
+	}
+}
@@ -6,6 +6,8 @@ require (
 	github.com/bugsnag/osext v0.0.0-20130617224835-0dd3f918b21b
 	github.com/google/go-github v17.0.0+incompatible
 	github.com/google/go-querystring v1.0.0 // indirect
-	golang.org/x/sys v0.0.0-20200812155832-6a926be9bd1d // indirect
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
+	golang.org/x/sys v0.0.0-20200812155832-6a926be9bd1d
 	gopkg.in/fsnotify.v1 v1.4.7
 )
@@ -0,0 +1,41 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// grafana posts an annotation to Grafana for each successful deploy.
+//
+// See https://grafana.com/docs/grafana/latest/http_api/annotations/
+type grafana struct {
+	URL   string
+	Token string
+}
+
+func (g *grafana) notify(d *deploy) error {
+	if d.Err != nil {
+		return nil
+	}
+	tags := []string{"deploy", d.Repo}
+	text := fmt.Sprintf("Deployed %s %s", d.Repo, d.After)
+	if d.Tag != "" {
+		tags = append(tags, d.Tag)
+		text += " (" + d.Tag + ")"
+	}
+	h := http.Header{}
+	if g.Token != "" {
+		h.Set("Authorization", "Bearer "+g.Token)
+	}
+	a := struct {
+		Time int64    `json:"time"`
+		Tags []string `json:"tags"`
+		Text string   `json:"text"`
+	}{d.Start.UnixNano() / 1e6, tags, text}
+	return postJSON(strings.TrimRight(g.URL, "/")+"/api/annotations", h, &a)
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// heartbeat pings a healthchecks.io compatible URL periodically and after
+// each successful deploy, so an alert fires when pullhook stops running.
+type heartbeat struct {
+	URL string
+}
+
+func (h *heartbeat) notify(d *deploy) error {
+	if d.Err != nil {
+		return nil
+	}
+	return h.ping()
+}
+
+// loop pings the URL every interval. It never returns.
+func (h *heartbeat) loop(interval time.Duration) {
+	for {
+		if err := h.ping(); err != nil {
+			log.Printf("- heartbeat failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (h *heartbeat) ping() error {
+	return post(h.URL, "", nil, nil)
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// jenkins triggers a Jenkins job after each successful deploy.
+//
+// See https://www.jenkins.io/doc/book/using/remote-access-api/
+type jenkins struct {
+	URL    string // URL of the job.
+	Token  string // Token set in the job's "Trigger builds remotely" option.
+	User   string // Optional user:apitoken for HTTP basic authentication.
+	params map[string]*template.Template
+}
+
+// newJenkins returns a Jenkins trigger. params is a comma separated list of
+// NAME=VALUE job parameters, where VALUE is a text/template executed with the
+// deploy, e.g. SHA={{.After}}.
+func newJenkins(u, token, user, params string) (*jenkins, error) {
+	j := &jenkins{URL: strings.TrimRight(u, "/"), Token: token, User: user, params: map[string]*template.Template{}}
+	for _, p := range strings.Split(params, ",") {
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid Jenkins parameter %q", p)
+		}
+		t, err := template.New(kv[0]).Parse(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		j.params[kv[0]] = t
+	}
+	return j, nil
+}
+
+func (j *jenkins) notify(d *deploy) error {
+	if d.Err != nil {
+		return nil
+	}
+	v := url.Values{}
+	for k, t := range j.params {
+		var b bytes.Buffer
+		if err := t.Execute(&b, d); err != nil {
+			return err
+		}
+		v.Set(k, b.String())
+	}
+	u := j.URL + "/build"
+	if len(v) != 0 {
+		u = j.URL + "/buildWithParameters"
+	}
+	if j.Token != "" {
+		u += "?token=" + url.QueryEscape(j.Token)
+	}
+	h := http.Header{}
+	if j.User != "" {
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(j.User)))
+	}
+	return post(u, "application/x-www-form-urlencoded", h, strings.NewReader(v.Encode()))
+}
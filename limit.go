@@ -0,0 +1,26 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// limitHandler runs at most n requests concurrently and replies with a 503
+// to the ones in excess.
+func limitHandler(h http.Handler, n int) http.Handler {
+	sem := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			h.ServeHTTP(w, r)
+		default:
+			log.Printf("%-4s %-21s %s  (too many requests in flight)", r.Method, r.RemoteAddr, r.URL.Path)
+			http.Error(w, "Too many requests", http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -6,19 +6,26 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
 	"sync"
 	"syscall"
+	"text/template"
 	"time"
 	"unicode/utf8"
 
@@ -26,6 +33,9 @@ import (
 
 	"github.com/bugsnag/osext"
 	"github.com/google/go-github/github"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
 )
 
 var start time.Time
@@ -63,13 +73,33 @@ func roundTime(t time.Duration) time.Duration {
 	return (t + time.Millisecond/2) / time.Millisecond * time.Millisecond
 }
 
-// pullRepo tries to pull a repository if possible. If the pull failed, it
-// deletes the checkout.
-func pullRepo() {
-	cmd := []string{"git", "pull", "--prune", "--quiet"}
+// run runs a command in the current directory and logs its output.
+//
+// It returns the combined output and an error if the command failed.
+func run(cmd ...string) (string, error) {
+	return runImpl("", true, cmd)
+}
+
+// runQuiet is like run but only logs the output if the command failed. It is
+// used when the output is not meant for the log.
+func runQuiet(cmd ...string) (string, error) {
+	return runImpl("", false, cmd)
+}
+
+// runIn is like run but runs the command in dir.
+func runIn(dir string, cmd ...string) (string, error) {
+	return runImpl(dir, true, cmd)
+}
+
+func runImpl(dir string, echo bool, cmd []string) (string, error) {
 	cmds := strings.Join(cmd, " ")
-	log.Printf("- %s", cmds)
+	if dir != "" {
+		log.Printf("- %s  (in %s)", cmds, dir)
+	} else {
+		log.Printf("- %s", cmds)
+	}
 	c := exec.Command(cmd[0], cmd[1:]...)
+	c.Dir = dir
 	start := time.Now()
 	out, err := c.CombinedOutput()
 	duration := time.Since(start)
@@ -85,14 +115,260 @@ func pullRepo() {
 			}
 		}
 	}
-	log.Printf("$ %s  (exit:%d in %s)\n%s", cmds, exit, roundTime(duration), normalizeUTF8(out))
+	out = normalizeUTF8(out)
+	if echo || err != nil {
+		log.Printf("$ %s  (exit:%d in %s)\n%s", cmds, exit, roundTime(duration), out)
+	} else {
+		log.Printf("$ %s  (exit:%d in %s)", cmds, exit, roundTime(duration))
+	}
+	if err != nil {
+		return string(out), fmt.Errorf("%s failed with exit code %d", cmds, exit)
+	}
+	return string(out), nil
+}
+
+// head returns the commit the checkout is currently at.
+func head() (string, error) {
+	out, err := run("git", "rev-parse", "HEAD")
+	return strings.TrimSpace(out), err
+}
+
+// deploy is the record of one pull.
+type deploy struct {
+	Repo     string        // Repository as named by the event.
+	Ref      string        // Ref that was pushed, or the image tag.
+	Image    string        // Image reference that was pushed, for registry events.
+	Before   string        // HEAD before the pull.
+	After    string        // HEAD after the pull.
+	Tag      string        // Tag pointing at After, if any.
+	Queued   time.Time     // When the deploy was requested.
+	Start    time.Time     // When the pull started.
+	Duration time.Duration // How long the pull and the steps around it took.
+	Summary  *summary      // What changed between Before and After.
+	Err      error         // Set if the deploy failed.
+}
+
+// key identifies the deploys that can be coalesced: pushes to the same
+// repository, or images pushed to the same registry repository.
+func (d *deploy) key() string {
+	if d.Image != "" {
+		return "image " + d.Repo
+	}
+	return d.Repo
+}
+
+// pullOptions are the steps run around each pull.
+type pullOptions struct {
+	Verify     []string // Command that must succeed for the pull to happen.
+	Restorecon bool     // Restore the SELinux contexts of the checkout.
+	// ImageCmd is run after the pull for registry events. It is a template
+	// executed with the deploy, then split on white space into arguments.
+	ImageCmd *template.Template
+}
+
+// pullRepo tries to pull a repository if possible and fills d.
+//
+// If opts.Verify is specified, it is run in a temporary worktree of the fetched
+// commit and the checkout is only fast-forwarded when it succeeds, so the
+// previous release stays active while and after it fails. If restoring the
+// SELinux contexts fails, the checkout is moved back to the commit it was at
+// before the pull.
+func pullRepo(d *deploy, opts *pullOptions) {
+	d.Start = time.Now()
+	d.Err = pullRepoImpl(d, opts)
+	d.Duration = time.Since(d.Start)
+	if d.Err != nil {
+		log.Printf("- deploy failed: %v", d.Err)
+	} else if d.Summary != nil {
+		log.Printf("- deployed %s: %s", d.After, d.Summary)
+	} else {
+		log.Printf("- deployed %s", d.After)
+	}
+}
+
+func pullRepoImpl(d *deploy, opts *pullOptions) error {
+	var err error
+	if d.Before, err = head(); err != nil {
+		return err
+	}
+	if _, err = run("git", "fetch", "--prune", "--quiet"); err != nil {
+		return err
+	}
+	out, err := run("git", "rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return err
+	}
+	if next := strings.TrimSpace(out); next != d.Before && len(opts.Verify) != 0 {
+		if err = verify(next, opts.Verify); err != nil {
+			return fmt.Errorf("%v; not deploying %s", err, next)
+		}
+	}
+	// A checkout with local commits is not fast-forwarded and is left alone.
+	if _, err = run("git", "merge", "--ff-only", "--quiet", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	if d.After, err = head(); err != nil {
+		return err
+	}
+	// The summary is informational; the pull already happened.
+	if d.Summary, err = summarize(d.Before, d.After); err != nil {
+		log.Printf("- summarizing %s..%s failed: %v", d.Before, d.After, err)
+	}
+	if out, err := run("git", "tag", "--points-at", d.After); err == nil {
+		d.Tag = strings.SplitN(strings.TrimSpace(out), "\n", 2)[0]
+	}
+	if opts.Restorecon {
+		if err = restorecon(wd); err != nil {
+			return rollback(d, err)
+		}
+	}
+	if d.Image == "" || opts.ImageCmd == nil {
+		return nil
+	}
+	var b bytes.Buffer
+	if err = opts.ImageCmd.Execute(&b, d); err != nil {
+		return err
+	}
+	cmd := strings.Fields(b.String())
+	if len(cmd) == 0 {
+		return errors.New("-image-cmd expanded to an empty command")
+	}
+	_, err = run(cmd...)
+	return err
+}
+
+// verify runs cmd in a temporary worktree checked out at commit.
+func verify(commit string, cmd []string) error {
+	dir, err := ioutil.TempDir("", "pullhook")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if _, err = run("git", "worktree", "add", "--detach", "--quiet", dir, commit); err != nil {
+		return err
+	}
+	defer run("git", "worktree", "remove", "--force", dir)
+	_, err = runIn(dir, cmd...)
+	return err
+}
+
+// rollback moves the checkout back to the commit it was at before the pull
+// after restoring the SELinux contexts failed with err, so the previous
+// release stays active.
+//
+// Unlike reset --hard, reset --keep never discards local changes; it fails
+// instead if they touch files changed by the pull.
+func rollback(d *deploy, err error) error {
+	if _, err2 := run("git", "reset", "--keep", "--quiet", d.Before); err2 != nil {
+		return fmt.Errorf("%v; rolling back to %s: %v", err, d.Before, err2)
+	}
+	// The files rewritten by the reset need their contexts restored too.
+	if err2 := restorecon(wd); err2 != nil {
+		return fmt.Errorf("%v; rolled back to %s but restoring its contexts failed: %v", err, d.Before, err2)
+	}
+	return fmt.Errorf("%v; rolled back to %s", err, d.Before)
 }
 
 // server is both the HTTP server and the task queue server.
 type server struct {
 	WebHookSecret string
+	Options       pullOptions    // Steps run around each pull.
+	Name          string         // Name of the checkout, as used in the API.
+	Remote        string         // URL of the origin remote of the checkout.
+	Notifiers     []notifier     // Notified after each deploy.
+	Cooldown      time.Duration  // Minimum interval between deploys of the checkout.
+	MaxPayload    int64          // Maximum size of a delivery body; 0 for no limit.
 	mu            sync.Mutex     // Set when a check is running
+	last          time.Time      // Start of the last deploy; protected by mu.
+	nmu           sync.Mutex     // Set when notifications are being sent.
 	wg            sync.WaitGroup // Set for each pending task.
+	done          chan struct{}  // Closed on shutdown to drop waiting deploys.
+
+	dmu      sync.Mutex // Protects the fields below.
+	previous string     // Commit deployed before current, if known.
+	current  string     // Commit currently deployed.
+
+	pmu     sync.Mutex         // Protects the field below.
+	pending map[string]*deploy // Deploy waiting to start, per deploy.key().
+}
+
+// enqueue runs a deploy asynchronously, one at a time.
+//
+// A deploy requested while another one of the same kind for the same
+// repository is still waiting replaces it, since a pull always fetches the
+// newest commit. The checkout is deployed at most once per s.Cooldown. Deploys
+// still waiting when s.done is closed are dropped.
+func (s *server) enqueue(d *deploy) {
+	d.Queued = time.Now()
+	key := d.key()
+	s.pmu.Lock()
+	if p := s.pending[key]; p != nil {
+		d.Queued = p.Queued
+		s.pending[key] = d
+		s.pmu.Unlock()
+		log.Printf("- coalesced with the pending deploy of %s", d.Repo)
+		return
+	}
+	s.pending[key] = d
+	s.pmu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.mu.Lock()
+		if wait := time.Until(s.last.Add(s.Cooldown)); wait > 0 {
+			s.pmu.Lock()
+			repo := s.pending[key].Repo
+			s.pmu.Unlock()
+			log.Printf("- deploy of %s delayed by %s", repo, roundTime(wait))
+			select {
+			case <-time.After(wait):
+			case <-s.done:
+			}
+		}
+		s.pmu.Lock()
+		d := s.pending[key]
+		delete(s.pending, key)
+		s.pmu.Unlock()
+		select {
+		case <-s.done:
+			s.mu.Unlock()
+			log.Printf("- dropped the pending deploy of %s on shutdown", d.Repo)
+			return
+		default:
+		}
+		s.last = time.Now()
+		s.pull(d)
+		s.mu.Unlock()
+		// Notifications can be slow; do not hold the next deploy.
+		s.nmu.Lock()
+		s.notify(d)
+		s.nmu.Unlock()
+	}()
+}
+
+// pull runs a deploy and records its result.
+func (s *server) pull(d *deploy) {
+	pullRepo(d, &s.Options)
+	if d.Err == nil && d.After != d.Before {
+		s.dmu.Lock()
+		s.previous = d.Before
+		s.current = d.After
+		s.dmu.Unlock()
+	}
+}
+
+// notify reports the result of a deploy to the event log and the notifiers.
+func (s *server) notify(d *deploy) {
+	if d.Err != nil {
+		logEvent(eventDeployFailed, fmt.Sprintf("Deploy of %s %s failed: %v", d.Repo, d.Ref, d.Err))
+	} else {
+		logEvent(eventDeployed, fmt.Sprintf("Deployed %s %s at %s", d.Repo, d.Ref, d.After))
+	}
+	for _, n := range s.Notifiers {
+		if err := n.notify(d); err != nil {
+			log.Printf("- notification failed: %v", err)
+		}
+	}
 }
 
 // ServeHTTP handles all HTTP requests and triggers a task if relevant.
@@ -103,6 +379,10 @@ type server struct {
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%-4s %-21s %s", r.Method, r.RemoteAddr, r.URL.Path)
 	defer r.Body.Close()
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		s.serveAPI(w, r)
+		return
+	}
 	// The path must be the root path.
 	if r.URL.Path != "" && r.URL.Path != "/" {
 		log.Printf("- Unexpected path %s", r.URL.Path)
@@ -123,40 +403,144 @@ func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Printf("- invalid method %s", r.Method)
 		return
 	}
+	if s.MaxPayload > 0 {
+		r.Body = &maxReader{ReadCloser: r.Body, n: s.MaxPayload}
+	}
+	if r.Header.Get("X-Gitlab-Event") != "" {
+		s.serveGitLab(w, r)
+		return
+	}
+	if r.Header.Get("X-Event-Key") != "" {
+		s.serveBitbucketServer(w, r)
+		return
+	}
+	if github.WebHookType(r) == "" {
+		s.serveRegistry(w, r)
+		return
+	}
+	if github.WebHookType(r) == "push" && r.Header.Get("Content-Type") == "application/json" {
+		s.serveGitHubPush(w, r)
+		return
+	}
 	payload, err := github.ValidatePayload(r, []byte(s.WebHookSecret))
+	if err == errTooLarge {
+		rejectBody(w, r, err)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Invalid secret", http.StatusUnauthorized)
-		log.Printf("- invalid secret")
+		reject(w, r, http.StatusUnauthorized, "secret")
 		return
 	}
-	if t := github.WebHookType(r); t != "ping" {
-		event, err := github.ParseWebHook(t, payload)
-		if err != nil {
-			http.Error(w, "Invalid payload", http.StatusBadRequest)
-			log.Printf("- invalid payload")
+	if t := github.WebHookType(r); t == "package" || t == "registry_package" {
+		s.serveGitHubPackage(w, r, payload)
+		return
+	}
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		reject(w, r, http.StatusBadRequest, "payload")
+		return
+	}
+	// Process the rest asynchronously so the hook doesn't take too long.
+	switch event := event.(type) {
+	case *github.PingEvent:
+		// The reply is visible in the hook's recent deliveries, so warnings are
+		// returned there too.
+		if warnings := checkHook(event.Hook); len(warnings) != 0 {
+			for _, m := range warnings {
+				log.Printf("- warning: %s", m)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string][]string{"warnings": warnings})
 			return
 		}
-		// Process the rest asynchronously so the hook doesn't take too long.
-		switch event := event.(type) {
-		case *github.PushEvent:
-			if event.HeadCommit == nil {
-				log.Printf("- Push %s %s <deleted>", *event.Repo.FullName, *event.Ref)
-			} else {
-				log.Printf("- Push %s %s %s", *event.Repo.FullName, *event.Ref, *event.HeadCommit.ID)
-				pullRepo()
-			}
-		default:
-			log.Printf("- ignoring hook type %s", reflect.TypeOf(event).Elem().Name())
+	case *github.PushEvent:
+		if event.HeadCommit == nil {
+			log.Printf("- Push %s %s <deleted>", *event.Repo.FullName, *event.Ref)
+		} else {
+			log.Printf("- Push %s %s %s", *event.Repo.FullName, *event.Ref, *event.HeadCommit.ID)
+			s.enqueue(&deploy{Repo: *event.Repo.FullName, Ref: *event.Ref})
 		}
+	default:
+		log.Printf("- ignoring hook type %s", reflect.TypeOf(event).Elem().Name())
 	}
 	io.WriteString(w, "{}")
 }
 
+// reject replies to a delivery that is not acted upon because part of it is
+// invalid. what names that part, e.g. "secret" or "payload".
+func reject(w http.ResponseWriter, r *http.Request, code int, what string) {
+	http.Error(w, "Invalid "+what, code)
+	log.Printf("- invalid %s", what)
+	logEvent(eventRejected, fmt.Sprintf("Rejected delivery from %s: invalid %s", r.RemoteAddr, what))
+}
+
+// checkHook returns the problems found in a hook configuration sent along a
+// ping event.
+func checkHook(h *github.Hook) []string {
+	if h == nil {
+		return nil
+	}
+	var out []string
+	if ct, _ := h.Config["content_type"].(string); ct != "" && ct != "json" {
+		out = append(out, fmt.Sprintf("content type is %q; use \"json\"", ct))
+	}
+	push := false
+	for _, e := range h.Events {
+		if e == "push" || e == "*" {
+			push = true
+		}
+	}
+	if len(h.Events) == 0 {
+		out = append(out, "hook is not subscribed to any event; pullhook only acts on \"push\"")
+	} else if !push {
+		out = append(out, fmt.Sprintf("hook is subscribed to %s; pullhook only acts on \"push\"", strings.Join(h.Events, ", ")))
+	}
+	return out
+}
+
 func mainImpl() error {
 	start = time.Now()
 	port := flag.Int("port", 0, "port to use")
 	secret := flag.String("secret", "", "secret to use")
+	verify := flag.String("verify", "", "command run in a temporary worktree of the fetched commit; the checkout is only updated if it succeeds")
+	grafanaURL := flag.String("grafana-url", "", "Grafana server URL to post deploy annotations to")
+	grafanaToken := flag.String("grafana-token", "", "Grafana API token")
+	statsdAddr := flag.String("statsd", "", "StatsD host:port to send metrics to")
+	statsdPrefix := flag.String("statsd-prefix", "pullhook.", "prefix of StatsD metric names")
+	statsdTags := flag.String("statsd-tags", "", "comma separated DogStatsD tags, e.g. env:prod,team:web")
+	cwNamespace := flag.String("cloudwatch-namespace", "", "CloudWatch namespace to publish deploy metrics to")
+	cwRegion := flag.String("cloudwatch-region", "", "AWS region; defaults to $AWS_REGION or the EC2 instance region")
+	cwDimensions := flag.String("cloudwatch-dimensions", "", "comma separated CloudWatch dimensions, e.g. Host=web1,Env=prod")
+	hcURL := flag.String("healthcheck-url", "", "healthchecks.io compatible URL to ping periodically and after each successful deploy")
+	hcInterval := flag.Duration("healthcheck-interval", 5*time.Minute, "interval between periodic heartbeat pings; 0 to only ping on deploys")
+	useH2C := flag.Bool("h2c", false, "accept HTTP/2 without TLS on the listener")
+	maxConns := flag.Int("max-conns", 0, "maximum number of simultaneous connections; 0 for no limit")
+	maxRequests := flag.Int("max-requests", 0, "maximum number of requests handled concurrently, the excess gets a 503; 0 for no limit")
+	useRestorecon := flag.Bool("restorecon", false, "restore the SELinux contexts of the checkout after each pull")
+	sshAddr := flag.String("ssh", "", "host:port of an SSH server to also serve from through a reverse port forward")
+	sshUser := flag.String("ssh-user", "", "user on the SSH server")
+	sshKey := flag.String("ssh-key", "", "private key file used to authenticate to the SSH server")
+	sshKnownHosts := flag.String("ssh-known-hosts", filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"), "known_hosts file used to verify the SSH server")
+	sshBind := flag.String("ssh-remote-addr", "localhost", "address to listen on, on the SSH server; the server's GatewayPorts setting must allow non-loopback addresses")
+	sshPort := flag.Int("ssh-remote-port", 0, "port to listen on, on the SSH server; 0 lets the server pick one")
+	jenkinsURL := flag.String("jenkins-url", "", "URL of a Jenkins job to trigger after each successful deploy")
+	jenkinsToken := flag.String("jenkins-token", "", "Jenkins job remote trigger token")
+	jenkinsUser := flag.String("jenkins-user", "", "Jenkins user:apitoken")
+	jenkinsParams := flag.String("jenkins-params", "", "comma separated Jenkins job parameters, templated with the deploy, e.g. SHA={{.After}},REF={{.Ref}}")
+	newRelicApp := flag.String("newrelic-app", "", "New Relic application ID to record deployment markers for")
+	newRelicKey := flag.String("newrelic-key", "", "New Relic REST API key")
+	pagerDutyKey := flag.String("pagerduty-key", "", "PagerDuty Events API v2 routing key to page on repeated deploy failures")
+	pagerDutyThreshold := flag.Int("pagerduty-threshold", 3, "consecutive deploy failures before triggering a PagerDuty incident")
+	ntfyServer := flag.String("ntfy-server", "https://ntfy.sh", "ntfy server URL")
+	ntfyTopic := flag.String("ntfy-topic", "", "ntfy topic to publish deploy results to")
+	ntfyAuth := flag.String("ntfy-auth", "", "ntfy access token, or user:password")
+	cooldown := flag.Duration("cooldown", 0, "minimum interval between deploys of the checkout; pushes in between are coalesced")
+	imageCmd := flag.String("image-cmd", "", "command to run after the pull on container registry pushes, templated with the deploy, e.g. docker service update --image {{.Image}} web")
+	maxPayload := flag.Int64("max-payload", 25<<20, "maximum size of a webhook delivery in bytes; 0 for no limit")
 	flag.Parse()
+	if *sshAddr != "" && (*sshUser == "" || *sshKey == "") {
+		return errors.New("-ssh requires -ssh-user and -ssh-key")
+	}
 	if runtime.GOOS != "windows" {
 		log.SetFlags(0)
 	}
@@ -165,23 +549,113 @@ func mainImpl() error {
 	if err != nil {
 		return err
 	}
-	s := server{WebHookSecret: *secret}
+	var imageTmpl *template.Template
+	if *imageCmd != "" {
+		if imageTmpl, err = template.New("image-cmd").Parse(*imageCmd); err != nil {
+			return err
+		}
+	}
+	s := server{
+		WebHookSecret: *secret,
+		Options:       pullOptions{Verify: strings.Fields(*verify), Restorecon: *useRestorecon, ImageCmd: imageTmpl},
+		Name:          filepath.Base(wd),
+		Cooldown:      *cooldown,
+		MaxPayload:    *maxPayload,
+		done:          make(chan struct{}),
+		pending:       map[string]*deploy{},
+	}
+	if s.current, err = head(); err != nil {
+		return err
+	}
+	// Seed the changelog range with the commit the checkout was at before, if
+	// the reflog knows it.
+	if out, err := run("git", "rev-parse", "--verify", "-q", "HEAD@{1}"); err == nil {
+		if p := strings.TrimSpace(out); p != s.current {
+			s.previous = p
+		}
+	}
+	if out, err := run("git", "config", "--get", "remote.origin.url"); err == nil {
+		s.Remote = strings.TrimSpace(out)
+	}
+	if *grafanaURL != "" {
+		s.Notifiers = append(s.Notifiers, &grafana{URL: *grafanaURL, Token: *grafanaToken})
+	}
+	if *statsdAddr != "" {
+		c, err := newStatsd(*statsdAddr, *statsdPrefix, *statsdTags)
+		if err != nil {
+			return err
+		}
+		s.Notifiers = append(s.Notifiers, c)
+	}
+	if *cwNamespace != "" {
+		c, err := newCloudwatch(*cwNamespace, *cwRegion, *cwDimensions)
+		if err != nil {
+			return err
+		}
+		s.Notifiers = append(s.Notifiers, c)
+	}
+	if *jenkinsURL != "" {
+		j, err := newJenkins(*jenkinsURL, *jenkinsToken, *jenkinsUser, *jenkinsParams)
+		if err != nil {
+			return err
+		}
+		s.Notifiers = append(s.Notifiers, j)
+	}
+	if *newRelicApp != "" {
+		s.Notifiers = append(s.Notifiers, &newRelic{AppID: *newRelicApp, APIKey: *newRelicKey})
+	}
+	if *pagerDutyKey != "" {
+		s.Notifiers = append(s.Notifiers, &pagerDuty{RoutingKey: *pagerDutyKey, Threshold: *pagerDutyThreshold, Name: s.Name})
+	}
+	if *ntfyTopic != "" {
+		s.Notifiers = append(s.Notifiers, &ntfy{Server: *ntfyServer, Topic: *ntfyTopic, Auth: *ntfyAuth})
+	}
+	if *hcURL != "" {
+		h := &heartbeat{URL: *hcURL}
+		s.Notifiers = append(s.Notifiers, h)
+		if *hcInterval > 0 {
+			go h.loop(*hcInterval)
+		}
+	}
 	// Run the web server.
 	http.Handle("/", &s)
 	thisFile, err := osext.Executable()
 	if err != nil {
 		return err
 	}
+	if err = openEventLog(); err != nil {
+		log.Printf("Failed to open event log: %v", err)
+	}
 	log.Printf("Running in: %s", wd)
 	log.Printf("Executable: %s", thisFile)
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
 	if err != nil {
 		return err
 	}
-	a := ln.Addr().String()
-	ln.Close()
-	log.Printf("Listening on: %s", a)
-	go http.ListenAndServe(a, nil)
+	log.Printf("Listening on: %s", ln.Addr())
+	logEvent(eventStart, fmt.Sprintf("Started in %s, listening on %s", wd, ln.Addr()))
+	if *maxConns > 0 {
+		ln = netutil.LimitListener(ln, *maxConns)
+	}
+	var h http.Handler = http.DefaultServeMux
+	if *maxRequests > 0 {
+		h = limitHandler(h, *maxRequests)
+	}
+	if *useH2C {
+		h = h2c.NewHandler(h, &http2.Server{})
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- http.Serve(ln, h)
+	}()
+	if *sshAddr != "" {
+		t := &tunnel{Addr: *sshAddr, User: *sshUser, KeyFile: *sshKey, KnownHosts: *sshKnownHosts, RemoteAddr: *sshBind, RemotePort: *sshPort}
+		go t.serve(h)
+	}
+	if err = sdNotify("READY=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	go sdWatchdog(ln.Addr())
 
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -190,17 +664,27 @@ func mainImpl() error {
 		log.Printf("Failed to initialize watcher: %v", err)
 	}
 
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	if err == nil {
 		select {
 		case <-w.Events:
 		case err = <-w.Errors:
 			log.Printf("Waiting failure: %v", err)
+		case err = <-served:
+		case <-sig:
 		}
 	} else {
 		// Hang so the server actually run.
-		select {}
+		select {
+		case err = <-served:
+		case <-sig:
+			err = nil
+		}
 	}
-	// Ensures no task is running.
+	sdNotify("STOPPING=1")
+	// Ensures no task is running. Deploys still waiting are dropped.
+	close(s.done)
 	s.wg.Wait()
 	return err
 }
@@ -0,0 +1,120 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestCheckHook(t *testing.T) {
+	data := []struct {
+		name string
+		in   *github.Hook
+		want []string
+	}{
+		{"nil", nil, nil},
+		{"json", &github.Hook{Config: map[string]interface{}{"content_type": "json"}, Events: []string{"push"}}, nil},
+		{"no content type", &github.Hook{Config: map[string]interface{}{}, Events: []string{"push", "ping"}}, nil},
+		{"wildcard", &github.Hook{Config: map[string]interface{}{"content_type": "json"}, Events: []string{"*"}}, nil},
+		{"form", &github.Hook{Config: map[string]interface{}{"content_type": "form"}, Events: []string{"push"}}, []string{`content type is "form"; use "json"`}},
+		{"no push", &github.Hook{Config: map[string]interface{}{"content_type": "json"}, Events: []string{"issues", "release"}}, []string{`hook is subscribed to issues, release; pullhook only acts on "push"`}},
+		{"no event", &github.Hook{Config: map[string]interface{}{"content_type": "form"}}, []string{`content type is "form"; use "json"`, `hook is not subscribed to any event; pullhook only acts on "push"`}},
+	}
+	for _, line := range data {
+		if got := checkHook(line.in); !reflect.DeepEqual(got, line.want) {
+			t.Errorf("%s: checkHook() = %q, want %q", line.name, got, line.want)
+		}
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	data := []struct {
+		name     string
+		cooldown time.Duration
+		in       []*deploy
+		want     map[string]string
+	}{
+		{
+			"one",
+			0,
+			[]*deploy{{Repo: "a/b", Ref: "1"}},
+			map[string]string{"a/b": "1"},
+		},
+		{
+			"coalesced",
+			0,
+			[]*deploy{{Repo: "a/b", Ref: "1"}, {Repo: "a/b", Ref: "2"}, {Repo: "a/b", Ref: "3"}},
+			map[string]string{"a/b": "3"},
+		},
+		{
+			"repos",
+			0,
+			[]*deploy{{Repo: "a/b", Ref: "1"}, {Repo: "a/c", Ref: "2"}, {Repo: "a/b", Ref: "3"}},
+			map[string]string{"a/b": "3", "a/c": "2"},
+		},
+		{
+			"kinds",
+			0,
+			[]*deploy{{Repo: "a/b", Ref: "v1", Image: "a/b:v1"}, {Repo: "a/b", Ref: "1"}, {Repo: "a/b", Ref: "v2", Image: "a/b:v2"}},
+			map[string]string{"a/b": "1", "image a/b": "v2"},
+		},
+		{
+			"cooldown",
+			time.Hour,
+			[]*deploy{{Repo: "a/b", Ref: "1"}, {Repo: "a/b", Ref: "2"}},
+			map[string]string{"a/b": "2"},
+		},
+	}
+	for _, line := range data {
+		s := &server{Cooldown: line.cooldown, last: time.Now(), done: make(chan struct{}), pending: map[string]*deploy{}}
+		// Without a cooldown, hold the queue so the deploys stay pending and are
+		// dropped instead of pulling. With one, the deploy waits out the
+		// cooldown while holding the queue.
+		if line.cooldown == 0 {
+			s.mu.Lock()
+		}
+		queued := map[string]time.Time{}
+		for _, d := range line.in {
+			s.enqueue(d)
+			if _, ok := queued[d.key()]; !ok {
+				queued[d.key()] = d.Queued
+			}
+			time.Sleep(time.Millisecond)
+		}
+		s.pmu.Lock()
+		got := map[string]string{}
+		for k, d := range s.pending {
+			got[k] = d.Ref
+			if !d.Queued.Equal(queued[k]) {
+				t.Errorf("%s: %s queued at %s, want %s", line.name, k, d.Queued, queued[k])
+			}
+		}
+		s.pmu.Unlock()
+		if !reflect.DeepEqual(got, line.want) {
+			t.Errorf("%s: pending = %v, want %v", line.name, got, line.want)
+		}
+		close(s.done)
+		if line.cooldown == 0 {
+			s.mu.Unlock()
+		}
+		c := make(chan struct{})
+		go func() {
+			s.wg.Wait()
+			close(c)
+		}()
+		select {
+		case <-c:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: waiting deploys were not dropped", line.name)
+		}
+		if len(s.pending) != 0 {
+			t.Errorf("%s: %d deploys left pending", line.name, len(s.pending))
+		}
+	}
+}
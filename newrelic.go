@@ -0,0 +1,40 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// newRelic records a deployment marker in New Relic APM for each successful
+// deploy.
+//
+// See https://docs.newrelic.com/docs/apm/new-relic-apm/maintenance/record-monitor-deployments/
+type newRelic struct {
+	AppID  string
+	APIKey string
+}
+
+func (n *newRelic) notify(d *deploy) error {
+	if d.Err != nil {
+		return nil
+	}
+	type deployment struct {
+		Revision    string `json:"revision"`
+		Changelog   string `json:"changelog,omitempty"`
+		Description string `json:"description"`
+		User        string `json:"user"`
+	}
+	m := struct {
+		Deployment deployment `json:"deployment"`
+	}{deployment{Revision: d.After, Description: d.Repo + " " + d.Ref, User: "pullhook"}}
+	if d.Summary != nil {
+		m.Deployment.Changelog = d.Summary.String()
+	}
+	h := http.Header{}
+	h.Set("X-Api-Key", n.APIKey)
+	return postJSON("https://api.newrelic.com/v2/applications/"+url.PathEscape(n.AppID)+"/deployments.json", h, &m)
+}
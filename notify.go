@@ -0,0 +1,57 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// notifier is notified of the result of each deploy.
+type notifier interface {
+	notify(d *deploy) error
+}
+
+// client is the HTTP client used by integrations.
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// post sends body to url with the headers in h and verifies that the request
+// succeeded.
+func post(url, contentType string, h http.Header, body io.Reader) error {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s: %s", url, resp.Status, bytes.TrimSpace(b))
+	}
+	return nil
+}
+
+// postJSON sends v encoded as JSON to url.
+func postJSON(url string, h http.Header, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(url, "application/json", h, bytes.NewReader(b))
+}
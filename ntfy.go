@@ -0,0 +1,46 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ntfy publishes the result of each deploy to an ntfy topic.
+//
+// See https://docs.ntfy.sh/publish/
+type ntfy struct {
+	Server string
+	Topic  string
+	Auth   string // Access token, or user:password.
+}
+
+func (n *ntfy) notify(d *deploy) error {
+	h := http.Header{}
+	var msg string
+	if d.Err != nil {
+		h.Set("Title", "Deploy of "+d.Repo+" failed")
+		h.Set("Tags", "x")
+		h.Set("Priority", "high")
+		msg = d.Err.Error()
+	} else {
+		h.Set("Title", "Deployed "+d.Repo)
+		h.Set("Tags", "white_check_mark")
+		msg = fmt.Sprintf("%s at %s", d.Ref, d.After)
+		if d.Summary != nil {
+			msg += "\n" + d.Summary.String()
+		}
+	}
+	if strings.Contains(n.Auth, ":") {
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(n.Auth)))
+	} else if n.Auth != "" {
+		h.Set("Authorization", "Bearer "+n.Auth)
+	}
+	return post(strings.TrimRight(n.Server, "/")+"/"+url.PathEscape(n.Topic), "text/plain", h, strings.NewReader(msg))
+}
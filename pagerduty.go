@@ -0,0 +1,69 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// pagerDuty triggers a PagerDuty incident when the deploys of the checkout
+// fail Threshold consecutive times, and resolves it on the next success.
+// Deploys are counted together whichever hook requested them.
+//
+// See https://developer.pagerduty.com/docs/events-api-v2/trigger-events/
+type pagerDuty struct {
+	RoutingKey string
+	Threshold  int
+	Name       string // Name of the checkout.
+
+	// Notifications are serialized so these need no lock.
+	failures  int
+	triggered bool
+}
+
+func (p *pagerDuty) notify(d *deploy) error {
+	if d.Err == nil {
+		p.failures = 0
+		if !p.triggered {
+			return nil
+		}
+		// Only forget the incident once PagerDuty acknowledged the resolution so
+		// the next deploy retries it.
+		if err := p.send("resolve", ""); err != nil {
+			return err
+		}
+		p.triggered = false
+		return nil
+	}
+	p.failures++
+	if p.triggered || p.failures < p.Threshold {
+		return nil
+	}
+	if err := p.send("trigger", fmt.Sprintf("pullhook: %d consecutive deploy failures for %s: %v", p.failures, p.Name, d.Err)); err != nil {
+		return err
+	}
+	p.triggered = true
+	return nil
+}
+
+func (p *pagerDuty) send(action, summary string) error {
+	type payload struct {
+		Summary  string `json:"summary"`
+		Source   string `json:"source"`
+		Severity string `json:"severity"`
+	}
+	e := struct {
+		RoutingKey  string   `json:"routing_key"`
+		EventAction string   `json:"event_action"`
+		DedupKey    string   `json:"dedup_key"`
+		Payload     *payload `json:"payload,omitempty"`
+	}{p.RoutingKey, action, "pullhook/" + p.Name, nil}
+	if action == "trigger" {
+		host, _ := os.Hostname()
+		e.Payload = &payload{summary, host, "error"}
+	}
+	return postJSON("https://events.pagerduty.com/v2/enqueue", nil, &e)
+}
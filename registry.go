@@ -0,0 +1,147 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// imagePush is a container image pushed to a registry.
+type imagePush struct {
+	Repo  string
+	Tag   string
+	Image string // Full reference, e.g. ghcr.io/owner/app:v1.
+}
+
+// serveRegistry handles a Docker Hub or Harbor image push delivery.
+//
+// Neither signs its deliveries. Docker Hub is authenticated with a secret
+// query parameter in the webhook URL and Harbor with the Authorization header
+// set in the webhook policy; both must match -secret.
+func (s *server) serveRegistry(w http.ResponseWriter, r *http.Request) {
+	if !s.validSecret(r.URL.Query().Get("secret")) && !s.validSecret(r.Header.Get("Authorization")) {
+		reject(w, r, http.StatusUnauthorized, "secret")
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rejectBody(w, r, err)
+		return
+	}
+	p, err := parseRegistryPush(b)
+	if err != nil {
+		reject(w, r, http.StatusBadRequest, "payload")
+		return
+	}
+	if p == nil {
+		log.Printf("- ignoring registry event")
+	} else {
+		s.enqueueImage(p)
+	}
+	io.WriteString(w, "{}")
+}
+
+// serveGitHubPackage handles a GitHub package or registry_package event, as
+// sent for images pushed to GHCR. The payload was already validated.
+func (s *server) serveGitHubPackage(w http.ResponseWriter, r *http.Request, payload []byte) {
+	var e struct {
+		Action          string         `json:"action"`
+		Package         *githubPackage `json:"package"`
+		RegistryPackage *githubPackage `json:"registry_package"`
+	}
+	if err := json.Unmarshal(payload, &e); err != nil {
+		reject(w, r, http.StatusBadRequest, "payload")
+		return
+	}
+	p := e.Package
+	if p == nil {
+		p = e.RegistryPackage
+	}
+	if e.Action != "published" || p == nil || (p.PackageType != "container" && p.PackageType != "CONTAINER") {
+		log.Printf("- ignoring package event %s", e.Action)
+	} else {
+		s.enqueueImage(&imagePush{
+			Repo:  p.Namespace + "/" + p.Name,
+			Tag:   p.PackageVersion.ContainerMetadata.Tag.Name,
+			Image: p.PackageVersion.PackageURL,
+		})
+	}
+	io.WriteString(w, "{}")
+}
+
+// githubPackage is the subset of a GitHub package used by pullhook.
+type githubPackage struct {
+	Name           string `json:"name"`
+	Namespace      string `json:"namespace"`
+	PackageType    string `json:"package_type"`
+	PackageVersion struct {
+		PackageURL        string `json:"package_url"`
+		ContainerMetadata struct {
+			Tag struct {
+				Name string `json:"name"`
+			} `json:"tag"`
+		} `json:"container_metadata"`
+	} `json:"package_version"`
+}
+
+func (s *server) enqueueImage(p *imagePush) {
+	log.Printf("- Image %s", p.Image)
+	s.enqueue(&deploy{Repo: p.Repo, Ref: p.Tag, Image: p.Image})
+}
+
+func (s *server) validSecret(v string) bool {
+	return v != "" && subtle.ConstantTimeCompare([]byte(v), []byte(s.WebHookSecret)) == 1
+}
+
+// parseRegistryPush parses a Docker Hub or Harbor webhook payload. It returns
+// nil if the event is not an image push.
+//
+// See https://docs.docker.com/docker-hub/webhooks/ and
+// https://goharbor.io/docs/main/working-with-projects/project-configuration/configure-webhooks/
+func parseRegistryPush(b []byte) (*imagePush, error) {
+	var e struct {
+		// Docker Hub.
+		PushData *struct {
+			Tag string `json:"tag"`
+		} `json:"push_data"`
+		Repository struct {
+			RepoName string `json:"repo_name"`
+		} `json:"repository"`
+		// Harbor.
+		Type      string `json:"type"`
+		EventData *struct {
+			Resources []struct {
+				Tag         string `json:"tag"`
+				ResourceURL string `json:"resource_url"`
+			} `json:"resources"`
+			Repository struct {
+				RepoFullName string `json:"repo_full_name"`
+			} `json:"repository"`
+		} `json:"event_data"`
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	switch {
+	case e.PushData != nil:
+		return &imagePush{Repo: e.Repository.RepoName, Tag: e.PushData.Tag, Image: e.Repository.RepoName + ":" + e.PushData.Tag}, nil
+	case e.EventData != nil:
+		if e.Type != "PUSH_ARTIFACT" && e.Type != "pushImage" {
+			return nil, nil
+		}
+		if len(e.EventData.Resources) == 0 {
+			return nil, errors.New("no resource")
+		}
+		res := e.EventData.Resources[0]
+		return &imagePush{Repo: e.EventData.Repository.RepoFullName, Tag: res.Tag, Image: res.ResourceURL}, nil
+	}
+	return nil, errors.New("unknown registry payload")
+}
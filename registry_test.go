@@ -0,0 +1,61 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRegistryPush(t *testing.T) {
+	data := []struct {
+		name string
+		in   string
+		want *imagePush
+		ok   bool
+	}{
+		{
+			"docker hub",
+			`{"push_data":{"tag":"v1","pusher":"alice"},"repository":{"repo_name":"a/b","namespace":"a"}}`,
+			&imagePush{Repo: "a/b", Tag: "v1", Image: "a/b:v1"},
+			true,
+		},
+		{
+			"harbor push",
+			`{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"tag":"v2","resource_url":"harbor.example.com/a/b:v2"}],"repository":{"repo_full_name":"a/b"}}}`,
+			&imagePush{Repo: "a/b", Tag: "v2", Image: "harbor.example.com/a/b:v2"},
+			true,
+		},
+		{
+			"harbor legacy push",
+			`{"type":"pushImage","event_data":{"resources":[{"tag":"v3","resource_url":"harbor.example.com/a/b:v3"}],"repository":{"repo_full_name":"a/b"}}}`,
+			&imagePush{Repo: "a/b", Tag: "v3", Image: "harbor.example.com/a/b:v3"},
+			true,
+		},
+		{
+			"harbor delete",
+			`{"type":"DELETE_ARTIFACT","event_data":{"resources":[{"tag":"v2","resource_url":"harbor.example.com/a/b:v2"}],"repository":{"repo_full_name":"a/b"}}}`,
+			nil,
+			true,
+		},
+		{
+			"harbor no resource",
+			`{"type":"PUSH_ARTIFACT","event_data":{"resources":[],"repository":{"repo_full_name":"a/b"}}}`,
+			nil,
+			false,
+		},
+		{"unknown", `{"event":"push"}`, nil, false},
+		{"invalid", `{"push_data":`, nil, false},
+	}
+	for _, line := range data {
+		got, err := parseRegistryPush([]byte(line.in))
+		if (err == nil) != line.ok {
+			t.Errorf("%s: parseRegistryPush() error = %v, want ok=%t", line.name, err, line.ok)
+		}
+		if !reflect.DeepEqual(got, line.want) {
+			t.Errorf("%s: parseRegistryPush() = %+v, want %+v", line.name, got, line.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state to systemd when running in a Type=notify unit. It is a
+// no-op otherwise.
+//
+// See https://www.freedesktop.org/software/systemd/man/sd_notify.html
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(state))
+	return err
+}
+
+// sdWatchdog sends keepalives to systemd at half the interval requested with
+// WatchdogSec, as long as a TCP connection to addr succeeds, so systemd
+// restarts the unit if the webhook listener is gone. It never returns if the
+// watchdog is enabled.
+func sdWatchdog(addr net.Addr) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	// The listener usually listens on all the interfaces.
+	target := addr.String()
+	if a, ok := addr.(*net.TCPAddr); ok && (a.IP == nil || a.IP.IsUnspecified()) {
+		target = net.JoinHostPort("localhost", strconv.Itoa(a.Port))
+	}
+	for {
+		if c, err := net.DialTimeout("tcp", target, interval); err != nil {
+			log.Printf("- listener is not accepting connections, skipping watchdog: %v", err)
+		} else {
+			c.Close()
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("- watchdog failed: %v", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
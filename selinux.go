@@ -0,0 +1,22 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// restorecon resets the SELinux contexts of the files in dir to the policy
+// defaults, so files fetched by git get the labels expected by the services
+// reading them. It is skipped when SELinux is not enabled.
+func restorecon(dir string) error {
+	if _, err := os.Stat("/sys/fs/selinux/enforce"); err != nil {
+		log.Printf("- SELinux is not enabled, skipping restorecon")
+		return nil
+	}
+	_, err := run("restorecon", "-R", dir)
+	return err
+}
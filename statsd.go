@@ -0,0 +1,58 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// statsd sends deploy metrics to a StatsD server.
+//
+// Tags use the DogStatsD extension and are only sent when specified.
+type statsd struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+}
+
+func newStatsd(addr, prefix, tags string) (*statsd, error) {
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &statsd{conn: c, prefix: prefix}
+	if tags != "" {
+		s.tags = "|#" + tags
+	}
+	return s, nil
+}
+
+func (s *statsd) notify(d *deploy) error {
+	var b bytes.Buffer
+	s.metric(&b, "pull.count", "1|c")
+	if d.Err != nil {
+		s.metric(&b, "pull.failure", "1|c")
+	}
+	s.metric(&b, "pull.duration", ms(d.Duration)+"|ms")
+	s.metric(&b, "queue.wait", ms(d.Start.Sub(d.Queued))+"|ms")
+	_, err := s.conn.Write(b.Bytes())
+	return err
+}
+
+// metric appends one metric line to b.
+func (s *statsd) metric(b *bytes.Buffer, name, value string) {
+	if b.Len() != 0 {
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(b, "%s%s:%s%s", s.prefix, name, value, s.tags)
+}
+
+// ms formats d as fractional milliseconds.
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%g", float64(d)/float64(time.Millisecond))
+}
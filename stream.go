@@ -0,0 +1,174 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// errTooLarge is returned by maxReader once the limit is exceeded.
+var errTooLarge = errors.New("payload too large")
+
+// maxReader fails once more than n bytes were read.
+type maxReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (m *maxReader) Read(p []byte) (int, error) {
+	n, err := m.ReadCloser.Read(p)
+	if m.n -= int64(n); m.n < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// rejectBody replies to a delivery whose body could not be read or decoded.
+func rejectBody(w http.ResponseWriter, r *http.Request, err error) {
+	if err == errTooLarge {
+		reject(w, r, http.StatusRequestEntityTooLarge, "payload size")
+	} else {
+		reject(w, r, http.StatusBadRequest, "payload")
+	}
+}
+
+// decodeObject decodes the JSON object read from r. The values of the keys
+// in fields are unmarshalled into the corresponding pointer and the other
+// values are skipped token by token, so the memory used is bounded by the
+// size of the fields of interest and not by the size of the payload.
+func decodeObject(r io.Reader, fields map[string]interface{}) error {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return errors.New("expected a JSON object")
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		k, _ := t.(string)
+		if v, ok := fields[k]; ok {
+			err = dec.Decode(v)
+		} else {
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// skipValue reads the next JSON value from dec and discards it.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// parseSignature parses an X-Hub-Signature header value, as sent by GitHub and
+// Bitbucket Server.
+func parseSignature(sig string) ([]byte, func() hash.Hash, error) {
+	p := strings.SplitN(sig, "=", 2)
+	if len(p) != 2 {
+		return nil, nil, errors.New("missing signature")
+	}
+	var h func() hash.Hash
+	switch p[0] {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha512":
+		h = sha512.New
+	default:
+		return nil, nil, errors.New("unknown signature hash")
+	}
+	mac, err := hex.DecodeString(p[1])
+	return mac, h, err
+}
+
+// validSignature returns true if sig, formatted as an X-Hub-Signature header
+// value, is the HMAC of body with secret.
+func validSignature(sig string, body []byte, secret string) bool {
+	want, h, err := parseSignature(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(want, mac.Sum(nil))
+}
+
+// serveGitHubPush handles a JSON GitHub push event.
+//
+// Push payloads embed every commit pushed and can be megabytes large, so the
+// body is hashed and decoded as it is read instead of being buffered. Nothing
+// is acted upon until the whole body matched the signature.
+func (s *server) serveGitHubPush(w http.ResponseWriter, r *http.Request) {
+	sig, h, err := parseSignature(r.Header.Get("X-Hub-Signature"))
+	if err != nil {
+		reject(w, r, http.StatusUnauthorized, "secret")
+		return
+	}
+	mac := hmac.New(h, []byte(s.WebHookSecret))
+	body := io.TeeReader(r.Body, mac)
+	var ref string
+	var repo struct {
+		FullName string `json:"full_name"`
+	}
+	var head *struct {
+		ID string `json:"id"`
+	}
+	decErr := decodeObject(body, map[string]interface{}{"ref": &ref, "repository": &repo, "head_commit": &head})
+	// The signature covers the whole body.
+	if _, err = io.Copy(ioutil.Discard, body); err != nil {
+		rejectBody(w, r, err)
+		return
+	}
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		reject(w, r, http.StatusUnauthorized, "secret")
+		return
+	}
+	if decErr != nil {
+		rejectBody(w, r, decErr)
+		return
+	}
+	if head == nil {
+		log.Printf("- Push %s %s <deleted>", repo.FullName, ref)
+	} else {
+		log.Printf("- Push %s %s %s", repo.FullName, ref, head.ID)
+		s.enqueue(&deploy{Repo: repo.FullName, Ref: ref})
+	}
+	io.WriteString(w, "{}")
+}
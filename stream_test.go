@@ -0,0 +1,137 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSignature(t *testing.T) {
+	data := []struct {
+		in   string
+		size int
+		ok   bool
+	}{
+		{"sha1=" + strings.Repeat("00", 20), 20, true},
+		{"sha256=" + strings.Repeat("ab", 32), 32, true},
+		{"sha512=" + strings.Repeat("AB", 64), 64, true},
+		{"", 0, false},
+		{"sha1", 0, false},
+		{"md5=00", 0, false},
+		{"sha1=zz", 0, false},
+	}
+	for _, line := range data {
+		mac, h, err := parseSignature(line.in)
+		if (err == nil) != line.ok {
+			t.Errorf("parseSignature(%q) error = %v, want ok=%t", line.in, err, line.ok)
+			continue
+		}
+		if line.ok && (len(mac) != line.size || h().Size() != line.size) {
+			t.Errorf("parseSignature(%q) = %d bytes, %d bytes hash, want %d", line.in, len(mac), h().Size(), line.size)
+		}
+	}
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"key":"value"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !validSignature(sig, body, "secret") {
+		t.Error("valid signature rejected")
+	}
+	if validSignature(sig, body, "other") {
+		t.Error("signature with the wrong secret accepted")
+	}
+	if validSignature(sig, body[1:], "secret") {
+		t.Error("signature of another body accepted")
+	}
+	if validSignature("", body, "secret") {
+		t.Error("missing signature accepted")
+	}
+}
+
+func TestDecodeObject(t *testing.T) {
+	data := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{`{"ref":"a"}`, "a", true},
+		{`{"commits":[{"id":"1","added":["x"]},{"id":"2"}],"ref":"b"}`, "b", true},
+		{`{"a":{"b":[1,{"c":null}]},"ref":"c","d":true}`, "c", true},
+		{`{"commits":[` + strings.Repeat(`{"id":"1"},`, 100000) + `{}],"ref":"d"}`, "d", true},
+		{`{}`, "", true},
+		{`[]`, "", false},
+		{`{"ref":"a"`, "a", false},
+		{`{"commits":[{"id":"1"}`, "", false},
+		{`{"ref":1}`, "", false},
+		{``, "", false},
+	}
+	for i, line := range data {
+		var ref string
+		err := decodeObject(strings.NewReader(line.in), map[string]interface{}{"ref": &ref})
+		if (err == nil) != line.ok {
+			t.Errorf("#%d: decodeObject() error = %v, want ok=%t", i, err, line.ok)
+		}
+		if ref != line.want {
+			t.Errorf("#%d: decodeObject() ref = %q, want %q", i, ref, line.want)
+		}
+	}
+}
+
+func TestServeGitHubPush(t *testing.T) {
+	push := `{"ref":"refs/heads/master","repository":{"full_name":"a/b"},"head_commit":{"id":"1"}}`
+	huge := `{"ref":"refs/heads/master","commits":[` + strings.Repeat(`{"id":"0","message":"m"},`, 10000) + `{}],"repository":{"full_name":"a/b"},"head_commit":{"id":"1"}}`
+	data := []struct {
+		name   string
+		body   string
+		secret string
+		max    int64
+		code   int
+		queued bool
+	}{
+		{"valid", push, "s3cret", 1024, http.StatusOK, true},
+		{"invalid signature", push, "other", 1024, http.StatusUnauthorized, false},
+		{"truncated", push[:len(push)-10], "s3cret", 1024, http.StatusBadRequest, false},
+		{"too large", push + strings.Repeat(" ", 1024), "s3cret", 1024, http.StatusRequestEntityTooLarge, false},
+		{"deleted", `{"ref":"refs/heads/master","repository":{"full_name":"a/b"},"head_commit":null}`, "s3cret", 1024, http.StatusOK, false},
+		{"huge commits", huge, "s3cret", 1 << 20, http.StatusOK, true},
+		{"no limit", huge, "s3cret", 0, http.StatusOK, true},
+	}
+	for _, line := range data {
+		s := &server{WebHookSecret: "s3cret", MaxPayload: line.max, done: make(chan struct{}), pending: map[string]*deploy{}}
+		mac := hmac.New(sha1.New, []byte(line.secret))
+		mac.Write([]byte(line.body))
+		r := httptest.NewRequest("POST", "/", strings.NewReader(line.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-GitHub-Event", "push")
+		r.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		// Hold the queue so the deploy stays pending and is dropped instead of
+		// pulling.
+		s.mu.Lock()
+		s.ServeHTTP(w, r)
+		d := s.pending["a/b"]
+		close(s.done)
+		s.mu.Unlock()
+		s.wg.Wait()
+		if w.Code != line.code {
+			t.Errorf("%s: code = %d, want %d", line.name, w.Code, line.code)
+		}
+		if (d != nil) != line.queued {
+			t.Errorf("%s: queued = %t, want %t", line.name, d != nil, line.queued)
+		} else if d != nil && (d.Repo != "a/b" || d.Ref != "refs/heads/master") {
+			t.Errorf("%s: deploy = %s %s", line.name, d.Repo, d.Ref)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// summary describes what changed between two commits.
+type summary struct {
+	Commits    int
+	Authors    []string
+	Files      int
+	Insertions int
+	Deletions  int
+}
+
+func (s *summary) String() string {
+	if s.Commits == 0 {
+		return "no new commits"
+	}
+	return fmt.Sprintf("%s by %s; %s changed, +%d -%d", plural(s.Commits, "commit"), strings.Join(s.Authors, ", "), plural(s.Files, "file"), s.Insertions, s.Deletions)
+}
+
+// plural returns n followed by noun, in the plural form if needed.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
+// summarize returns the summary of the changes between commits old and new.
+func summarize(old, new string) (*summary, error) {
+	s := &summary{}
+	if old == new {
+		return s, nil
+	}
+	out, err := run("git", "log", "--format=%an", old+".."+new)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, a := range strings.Split(strings.TrimSpace(out), "\n") {
+		if a == "" {
+			continue
+		}
+		s.Commits++
+		if !seen[a] {
+			seen[a] = true
+			s.Authors = append(s.Authors, a)
+		}
+	}
+	if out, err = run("git", "diff", "--numstat", old, new); err != nil {
+		return nil, err
+	}
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		f := strings.SplitN(l, "\t", 3)
+		if len(f) != 3 {
+			continue
+		}
+		s.Files++
+		// Binary files are reported as "-".
+		i, _ := strconv.Atoi(f[0])
+		d, _ := strconv.Atoi(f[1])
+		s.Insertions += i
+		s.Deletions += d
+	}
+	return s, nil
+}
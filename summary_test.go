@@ -0,0 +1,24 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestSummaryString(t *testing.T) {
+	data := []struct {
+		in   summary
+		want string
+	}{
+		{summary{}, "no new commits"},
+		{summary{Commits: 1, Authors: []string{"Alice"}, Files: 1, Insertions: 2}, "1 commit by Alice; 1 file changed, +2 -0"},
+		{summary{Commits: 3, Authors: []string{"Alice", "Bob"}, Files: 2, Insertions: 5, Deletions: 1}, "3 commits by Alice, Bob; 2 files changed, +5 -1"},
+		{summary{Commits: 1, Authors: []string{"Alice"}}, "1 commit by Alice; 0 files changed, +0 -0"},
+	}
+	for _, line := range data {
+		if got := line.in.String(); got != line.want {
+			t.Errorf("%+v.String() = %q, want %q", line.in, got, line.want)
+		}
+	}
+}
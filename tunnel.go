@@ -0,0 +1,79 @@
+// Copyright 2017 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// tunnel serves HTTP requests through a reverse port forward opened on an SSH
+// server, for hosts that do not accept inbound connections.
+type tunnel struct {
+	Addr       string // host:port of the SSH server.
+	User       string
+	KeyFile    string // Private key used to authenticate.
+	KnownHosts string // known_hosts file used to verify the server.
+	RemoteAddr string // Address to listen on, on the SSH server.
+	RemotePort int    // Port to listen on, on the SSH server. 0 picks one.
+}
+
+// serve serves h through the tunnel, reconnecting as needed. It never
+// returns.
+func (t *tunnel) serve(h http.Handler) {
+	for {
+		err := t.serveOnce(h)
+		log.Printf("Tunnel failure: %v", err)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (t *tunnel) serveOnce(h http.Handler) error {
+	key, err := ioutil.ReadFile(t.KeyFile)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	hostKey, err := knownhosts.New(t.KnownHosts)
+	if err != nil {
+		return err
+	}
+	c, err := ssh.Dial("tcp", t.Addr, &ssh.ClientConfig{
+		User:            t.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKey,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ln, err := c.Listen("tcp", net.JoinHostPort(t.RemoteAddr, strconv.Itoa(t.RemotePort)))
+	if err != nil {
+		return err
+	}
+	log.Printf("Tunnel listening on: %s via %s", ln.Addr(), t.Addr)
+	go func() {
+		// Detect dead connections, which would otherwise hang forever.
+		for {
+			time.Sleep(30 * time.Second)
+			if _, _, err := c.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}()
+	return http.Serve(ln, h)
+}